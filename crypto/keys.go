@@ -0,0 +1,60 @@
+// Package crypto содержит ed25519-ключи счетов. PrivAccount оборачивает
+// приватный ключ и умеет подписывать байты; PubKey - открытый ключ,
+// который прикрепляется к Account и используется для проверки подписи
+// транзакций, не раскрывая сам приватный ключ.
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+)
+
+// PubKey - открытый ключ счета (32 байта ed25519).
+type PubKey [ed25519.PublicKeySize]byte
+
+// Signature - подпись сообщения приватным ключом счета (64 байта ed25519).
+type Signature [ed25519.SignatureSize]byte
+
+// PrivAccount - пара ключей счета: PubKey выдается наружу и хранится в
+// Account, Key остается только у владельца и нужен для подписи транзакций.
+type PrivAccount struct {
+	PubKey PubKey
+	Key    [ed25519.PrivateKeySize]byte
+}
+
+// GenPrivAccount генерирует новую случайную пару ключей.
+func GenPrivAccount() (*PrivAccount, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var key [ed25519.PrivateKeySize]byte
+	copy(key[:], priv)
+	return GenPrivAccountFromKey(key), nil
+}
+
+// GenPrivAccountFromKey восстанавливает PrivAccount из сырого 64-байтного
+// ed25519-ключа (например, прочитанного из файла, в который он был сохранен
+// при создании счета).
+func GenPrivAccountFromKey(key [ed25519.PrivateKeySize]byte) *PrivAccount {
+	priv := ed25519.PrivateKey(key[:])
+	pub := priv.Public().(ed25519.PublicKey)
+
+	var pubKey PubKey
+	copy(pubKey[:], pub)
+
+	return &PrivAccount{PubKey: pubKey, Key: key}
+}
+
+// Sign подписывает произвольные байты сообщения приватным ключом счета.
+func (pa *PrivAccount) Sign(msg []byte) Signature {
+	sig := ed25519.Sign(ed25519.PrivateKey(pa.Key[:]), msg)
+	var out Signature
+	copy(out[:], sig)
+	return out
+}
+
+// Verify проверяет, что signature - подлинная подпись msg открытым ключом pub.
+func Verify(pub PubKey, msg []byte, signature Signature) bool {
+	return ed25519.Verify(ed25519.PublicKey(pub[:]), msg, signature[:])
+}
@@ -0,0 +1,100 @@
+package vm
+
+import "fmt"
+
+// OpCode - код операции опкод-программы, получаемой компилятором из AST.
+type OpCode int
+
+const (
+	// OpPushSource регистрирует очередной источник средств и допустимый по
+	// нему овердрафт (0, если овердрафт не разрешен).
+	OpPushSource OpCode = iota
+	// OpSetMonetary задает общую сумму и валюту перевода.
+	OpSetMonetary
+	// OpDestPercent отправляет долю (в базисных пунктах) суммы на счет.
+	OpDestPercent
+	// OpDestRemaining отправляет весь остаток суммы на счет.
+	OpDestRemaining
+)
+
+// Instruction - одна инструкция опкод-программы.
+type Instruction struct {
+	Op          OpCode
+	Account     string // OpPushSource, OpDestPercent, OpDestRemaining
+	Currency    string // OpSetMonetary
+	Amount      int64  // OpSetMonetary: сумма перевода; OpPushSource: лимит овердрафта
+	BasisPoints int64  // OpDestPercent: доля, 1% = 100
+}
+
+// Program - скомпилированная последовательность инструкций для Machine.
+type Program []Instruction
+
+// Compile разбирает текст скрипта и компилирует его в Program, подставляя
+// именованные переменные счетов из vars. Идентификатор, не объявленный в
+// блоке vars { ... }, трактуется как буквальный ID счета.
+func Compile(script string, vars map[string]Value) (Program, error) {
+	ast, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	declared := make(map[string]bool, len(ast.Vars))
+	for _, name := range ast.Vars {
+		declared[name] = true
+	}
+
+	resolve := func(name string) (string, error) {
+		if !declared[name] {
+			return name, nil
+		}
+		val, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("vm: переменная %q не передана в Execute", name)
+		}
+		if val.Kind != KindAccount {
+			return "", fmt.Errorf("vm: переменная %q должна быть счетом", name)
+		}
+		return val.Account, nil
+	}
+
+	var program Program
+
+	// OpSetMonetary идет первым, чтобы к моменту выполнения OpPushSource
+	// валюта перевода уже была известна - баланс источника запрашивается
+	// в этой валюте.
+	program = append(program, Instruction{
+		Op:       OpSetMonetary,
+		Currency: ast.Currency,
+		Amount:   ast.Amount,
+	})
+
+	for _, src := range ast.Sources {
+		account, err := resolve(src.Account)
+		if err != nil {
+			return nil, err
+		}
+		program = append(program, Instruction{
+			Op:      OpPushSource,
+			Account: account,
+			Amount:  src.Overdraft,
+		})
+	}
+
+	for _, dst := range ast.Dests {
+		account, err := resolve(dst.Account)
+		if err != nil {
+			return nil, err
+		}
+		if dst.Remaining {
+			program = append(program, Instruction{Op: OpDestRemaining, Account: account})
+			continue
+		}
+		program = append(program, Instruction{
+			Op:          OpDestPercent,
+			Account:     account,
+			BasisPoints: dst.BasisPoints,
+		})
+	}
+
+	return program, nil
+}
@@ -0,0 +1,24 @@
+package vm
+
+// DeclaredVars возвращает имена переменных счетов, объявленных в блоке
+// vars { ... } скрипта, в порядке объявления. Используется вызывающей
+// стороной (например, CLI), чтобы узнать, какие переменные нужно передать
+// в Execute, не разбирая скрипт вручную.
+func DeclaredVars(script string) ([]string, error) {
+	ast, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+	return ast.Vars, nil
+}
+
+// Execute компилирует script и выполняет его на Machine, используя balances
+// для получения текущего остатка каждого объявленного источника. Возвращает
+// список проводок, которые нужно применить к реальному хранилищу счетов.
+func Execute(script string, vars map[string]Value, balances func(account, currency string) (int64, error)) ([]Posting, error) {
+	program, err := Compile(script, vars)
+	if err != nil {
+		return nil, err
+	}
+	return NewMachine(program, vars, balances).Run()
+}
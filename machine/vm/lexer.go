@@ -0,0 +1,93 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokPercent // число, сразу за которым следует '%'
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex разбивает текст скрипта на токены. Пунктуация DSL ограничена
+// символами {} [] () и '%', поэтому однопроходного сканера достаточно -
+// экранирование и строковые литералы в поддерживаемом подмножестве не нужны.
+func lex(script string) ([]token, error) {
+	var tokens []token
+	runes := []rune(script)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '{':
+			tokens = append(tokens, token{tokLBrace, "{"})
+			i++
+		case r == '}':
+			tokens = append(tokens, token{tokRBrace, "}"})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBracket, "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBracket, "]"})
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")"})
+			i++
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			if i < len(runes) && runes[i] == '%' {
+				i++
+				tokens = append(tokens, token{tokPercent, text})
+			} else {
+				tokens = append(tokens, token{tokNumber, text})
+			}
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, string(runes[start:i])})
+
+		default:
+			return nil, fmt.Errorf("vm: неожиданный символ %q в скрипте", r)
+		}
+	}
+
+	tokens = append(tokens, token{tokEOF, ""})
+	return tokens, nil
+}
+
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
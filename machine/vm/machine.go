@@ -0,0 +1,173 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInsufficientFunds возвращается Run/Step, когда объявленных источников
+// (с учетом их овердрафта) не хватает, чтобы покрыть сумму перевода.
+var ErrInsufficientFunds = errors.New("vm: источников недостаточно для покрытия суммы перевода")
+
+// sourceFunds - остаток, доступный для списания с одного источника в рамках
+// текущего выполнения программы.
+type sourceFunds struct {
+	Account   string
+	Available int64
+}
+
+// Machine - стековая виртуальная машина, выполняющая Program пошагово.
+// P - указатель на следующую инструкцию; Vars - переменные скрипта;
+// Resources - доступные на момент выполнения ресурсы по каждому источнику
+// (заполняется по мере выполнения OpPushSource); Postings - итоговые
+// проводки, накопленные к моменту завершения программы.
+type Machine struct {
+	P         uint
+	Program   Program
+	Vars      map[string]Value
+	Resources []Value
+	Postings  []Posting
+
+	// Balances возвращает текущий баланс счета в указанной валюте; вызывается
+	// при выполнении OpPushSource (когда валюта перевода уже известна из
+	// OpSetMonetary), чтобы узнать реальный остаток без того, чтобы пакет vm
+	// знал что-либо о Storage.
+	Balances func(account, currency string) (int64, error)
+
+	currency  string
+	initial   int64
+	remaining int64
+	sources   []sourceFunds
+}
+
+// NewMachine создает Machine, готовую к выполнению program. balances
+// вызывается для получения текущего баланса каждого объявленного источника.
+func NewMachine(program Program, vars map[string]Value, balances func(account, currency string) (int64, error)) *Machine {
+	return &Machine{
+		Program:  program,
+		Vars:     vars,
+		Balances: balances,
+	}
+}
+
+// Run выполняет программу до конца и возвращает накопленные проводки.
+func (m *Machine) Run() ([]Posting, error) {
+	for {
+		done, err := m.Step()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return m.Postings, nil
+		}
+	}
+}
+
+// Step выполняет ровно одну инструкцию и продвигает P. Возвращает done=true,
+// когда программа исчерпана.
+func (m *Machine) Step() (done bool, err error) {
+	if int(m.P) >= len(m.Program) {
+		return true, nil
+	}
+	instr := m.Program[m.P]
+
+	switch instr.Op {
+	case OpPushSource:
+		if err := m.pushSource(instr.Account, instr.Amount); err != nil {
+			return false, err
+		}
+	case OpSetMonetary:
+		m.currency = instr.Currency
+		m.initial = instr.Amount
+		m.remaining = instr.Amount
+	case OpDestPercent:
+		share := (m.initial * instr.BasisPoints) / 10000
+		if share > m.remaining {
+			share = m.remaining
+		}
+		if err := m.allocate(instr.Account, share); err != nil {
+			return false, err
+		}
+	case OpDestRemaining:
+		if err := m.allocate(instr.Account, m.remaining); err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("vm: неизвестная инструкция %d", instr.Op)
+	}
+
+	m.P++
+	return int(m.P) >= len(m.Program), nil
+}
+
+func (m *Machine) pushSource(account string, overdraft int64) error {
+	balance := int64(0)
+	if m.Balances != nil {
+		b, err := m.Balances(account, m.currency)
+		if err != nil {
+			return err
+		}
+		balance = b
+	}
+	available := balance + overdraft
+
+	m.Resources = append(m.Resources, Value{
+		Kind:    KindMonetary,
+		Account: account,
+		Monetary: Monetary{
+			Currency: m.currency,
+			Amount:   available,
+		},
+	})
+	m.sources = append(m.sources, sourceFunds{Account: account, Available: available})
+	return nil
+}
+
+func (m *Machine) totalAvailable() int64 {
+	var total int64
+	for _, s := range m.sources {
+		total += s.Available
+	}
+	return total
+}
+
+// allocate списывает amount с источников в порядке их объявления (первый
+// источник используется полностью, прежде чем перейти к следующему) и
+// зачисляет ту же сумму на destination одной проводкой на каждый
+// задействованный источник.
+func (m *Machine) allocate(destination string, amount int64) error {
+	if amount <= 0 {
+		return nil
+	}
+	if amount > m.totalAvailable() {
+		return ErrInsufficientFunds
+	}
+
+	left := amount
+	for i := range m.sources {
+		if left == 0 {
+			break
+		}
+		src := &m.sources[i]
+		if src.Available == 0 {
+			continue
+		}
+
+		drawn := src.Available
+		if drawn > left {
+			drawn = left
+		}
+		src.Available -= drawn
+		left -= drawn
+
+		m.Postings = append(m.Postings, Posting{
+			Source:      src.Account,
+			Destination: destination,
+			Currency:    m.currency,
+			Amount:      drawn,
+		})
+	}
+
+	m.remaining -= amount
+	return nil
+}
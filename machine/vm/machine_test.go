@@ -0,0 +1,181 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+func balancesFrom(m map[string]int64) func(account, currency string) (int64, error) {
+	return func(account, currency string) (int64, error) {
+		balance, ok := m[account]
+		if !ok {
+			return 0, errors.New("vm: неизвестный счет " + account)
+		}
+		return balance, nil
+	}
+}
+
+func postingsSum(postings []Posting, destination string) int64 {
+	var sum int64
+	for _, p := range postings {
+		if p.Destination == destination {
+			sum += p.Amount
+		}
+	}
+	return sum
+}
+
+func TestExecuteSplitPercentagePayout(t *testing.T) {
+	script := `
+		vars {
+			account alice
+			account carol
+			account dave
+		}
+		send [USD 1000] (
+			source {
+				account alice
+			}
+			destination {
+				50% to carol
+				remaining to dave
+			}
+		)
+	`
+	vars := map[string]Value{
+		"alice": {Kind: KindAccount, Account: "ACC0001"},
+		"carol": {Kind: KindAccount, Account: "ACC0002"},
+		"dave":  {Kind: KindAccount, Account: "ACC0003"},
+	}
+
+	postings, err := Execute(script, vars, balancesFrom(map[string]int64{"ACC0001": 1000}))
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+
+	if got := postingsSum(postings, "ACC0002"); got != 500 {
+		t.Errorf("carol share = %d, want 500", got)
+	}
+	if got := postingsSum(postings, "ACC0003"); got != 500 {
+		t.Errorf("dave share = %d, want 500", got)
+	}
+	for _, p := range postings {
+		if p.Source != "ACC0001" || p.Currency != "USD" {
+			t.Errorf("unexpected posting %+v", p)
+		}
+	}
+}
+
+func TestExecuteFeeTap(t *testing.T) {
+	script := `
+		vars {
+			account alice
+			account fees
+			account dave
+		}
+		send [USD 1000] (
+			source {
+				account alice
+			}
+			destination {
+				2% to fees
+				remaining to dave
+			}
+		)
+	`
+	vars := map[string]Value{
+		"alice": {Kind: KindAccount, Account: "ACC0001"},
+		"fees":  {Kind: KindAccount, Account: "ACC0099"},
+		"dave":  {Kind: KindAccount, Account: "ACC0003"},
+	}
+
+	postings, err := Execute(script, vars, balancesFrom(map[string]int64{"ACC0001": 1000}))
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+
+	if got := postingsSum(postings, "ACC0099"); got != 20 {
+		t.Errorf("fee share = %d, want 20", got)
+	}
+	if got := postingsSum(postings, "ACC0003"); got != 980 {
+		t.Errorf("dave share = %d, want 980", got)
+	}
+}
+
+func TestExecuteMultipleSourcesWithOverdraft(t *testing.T) {
+	script := `
+		vars {
+			account alice
+			account bob
+			account carol
+		}
+		send [USD 50] (
+			source {
+				account alice
+				account bob allowing overdraft up to [USD 20]
+			}
+			destination {
+				remaining to carol
+			}
+		)
+	`
+	vars := map[string]Value{
+		"alice": {Kind: KindAccount, Account: "ACC0001"},
+		"bob":   {Kind: KindAccount, Account: "ACC0002"},
+		"carol": {Kind: KindAccount, Account: "ACC0003"},
+	}
+
+	postings, err := Execute(script, vars, balancesFrom(map[string]int64{
+		"ACC0001": 30,
+		"ACC0002": 0,
+	}))
+	if err != nil {
+		t.Fatalf("Execute() returned error: %v", err)
+	}
+
+	if got := postingsSum(postings, "ACC0003"); got != 50 {
+		t.Errorf("carol total = %d, want 50", got)
+	}
+
+	var fromAlice, fromBob int64
+	for _, p := range postings {
+		switch p.Source {
+		case "ACC0001":
+			fromAlice += p.Amount
+		case "ACC0002":
+			fromBob += p.Amount
+		}
+	}
+	if fromAlice != 30 {
+		t.Errorf("drawn from alice = %d, want 30", fromAlice)
+	}
+	if fromBob != 20 {
+		t.Errorf("drawn from bob (overdraft) = %d, want 20", fromBob)
+	}
+}
+
+func TestExecuteInsufficientFundsAborts(t *testing.T) {
+	script := `
+		vars {
+			account alice
+			account dave
+		}
+		send [USD 100] (
+			source {
+				account alice
+			}
+			destination {
+				remaining to dave
+			}
+		)
+	`
+	vars := map[string]Value{
+		"alice": {Kind: KindAccount, Account: "ACC0001"},
+		"dave":  {Kind: KindAccount, Account: "ACC0003"},
+	}
+
+	_, err := Execute(script, vars, balancesFrom(map[string]int64{"ACC0001": 50}))
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Execute() error = %v, want ErrInsufficientFunds", err)
+	}
+}
@@ -0,0 +1,248 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// sourceDecl - один источник средств, как он описан в блоке source { ... }.
+// Overdraft остается нулем, если "allowing overdraft up to ..." не указан.
+type sourceDecl struct {
+	Account   string
+	Overdraft int64
+}
+
+// destDecl - один получатель, как он описан в блоке destination { ... }.
+// Ровно одно из полей (BasisPoints либо Remaining) применимо в зависимости
+// от Kind.
+type destDecl struct {
+	Account     string
+	Remaining   bool
+	BasisPoints int64 // доля в базисных пунктах (1% = 100), если !Remaining
+}
+
+// scriptAST - разобранная программа: один send с явным списком источников и
+// получателей. Поддержка нескольких send в одном скрипте не предусмотрена -
+// этого достаточно для DSL переводов с долевой выплатой и комиссией.
+type scriptAST struct {
+	Vars     []string
+	Currency string
+	Amount   int64
+	Sources  []sourceDecl
+	Dests    []destDecl
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse разбирает текст скрипта в scriptAST.
+func parse(script string) (*scriptAST, error) {
+	tokens, err := lex(script)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseScript()
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	t := p.next()
+	if !t.isKeyword(keyword) {
+		return fmt.Errorf("vm: ожидалось ключевое слово %q, получено %q", keyword, t.text)
+	}
+	return nil
+}
+
+func (p *parser) expectKind(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("vm: ожидался %s, получено %q", what, t.text)
+	}
+	return t, nil
+}
+
+func (p *parser) expectIdent() (string, error) {
+	t, err := p.expectKind(tokIdent, "идентификатор")
+	return t.text, err
+}
+
+func (p *parser) parseScript() (*scriptAST, error) {
+	ast := &scriptAST{}
+
+	if p.peek().isKeyword("vars") {
+		p.next()
+		if _, err := p.expectKind(tokLBrace, "'{'"); err != nil {
+			return nil, err
+		}
+		for p.peek().isKeyword("account") {
+			p.next()
+			name, err := p.expectIdent()
+			if err != nil {
+				return nil, err
+			}
+			ast.Vars = append(ast.Vars, name)
+		}
+		if _, err := p.expectKind(tokRBrace, "'}'"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectKeyword("send"); err != nil {
+		return nil, err
+	}
+	currency, amount, err := p.parseMonetary()
+	if err != nil {
+		return nil, err
+	}
+	ast.Currency = currency
+	ast.Amount = amount
+
+	if _, err := p.expectKind(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("source"); err != nil {
+		return nil, err
+	}
+	if _, err := p.expectKind(tokLBrace, "'{'"); err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("account") {
+		decl, err := p.parseSourceDecl()
+		if err != nil {
+			return nil, err
+		}
+		ast.Sources = append(ast.Sources, decl)
+	}
+	if _, err := p.expectKind(tokRBrace, "'}'"); err != nil {
+		return nil, err
+	}
+	if len(ast.Sources) == 0 {
+		return nil, fmt.Errorf("vm: блок source должен содержать хотя бы один счет")
+	}
+
+	if err := p.expectKeyword("destination"); err != nil {
+		return nil, err
+	}
+	if _, err := p.expectKind(tokLBrace, "'{'"); err != nil {
+		return nil, err
+	}
+	for p.peek().kind != tokRBrace {
+		decl, err := p.parseDestDecl()
+		if err != nil {
+			return nil, err
+		}
+		ast.Dests = append(ast.Dests, decl)
+	}
+	if _, err := p.expectKind(tokRBrace, "'}'"); err != nil {
+		return nil, err
+	}
+	if len(ast.Dests) == 0 {
+		return nil, fmt.Errorf("vm: блок destination должен содержать хотя бы одного получателя")
+	}
+
+	if _, err := p.expectKind(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+
+	return ast, nil
+}
+
+// parseMonetary разбирает литерал суммы вида "[USD 100]".
+func (p *parser) parseMonetary() (currency string, amount int64, err error) {
+	if _, err = p.expectKind(tokLBracket, "'['"); err != nil {
+		return "", 0, err
+	}
+	currency, err = p.expectIdent()
+	if err != nil {
+		return "", 0, err
+	}
+	amountTok, err := p.expectKind(tokNumber, "число")
+	if err != nil {
+		return "", 0, err
+	}
+	amount, err = strconv.ParseInt(amountTok.text, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("vm: некорректная сумма %q", amountTok.text)
+	}
+	if _, err = p.expectKind(tokRBracket, "']'"); err != nil {
+		return "", 0, err
+	}
+	return currency, amount, nil
+}
+
+func (p *parser) parseSourceDecl() (sourceDecl, error) {
+	p.next() // "account"
+	name, err := p.expectIdent()
+	if err != nil {
+		return sourceDecl{}, err
+	}
+
+	decl := sourceDecl{Account: name}
+
+	if p.peek().isKeyword("allowing") {
+		p.next()
+		if err := p.expectKeyword("overdraft"); err != nil {
+			return sourceDecl{}, err
+		}
+		if err := p.expectKeyword("up"); err != nil {
+			return sourceDecl{}, err
+		}
+		if err := p.expectKeyword("to"); err != nil {
+			return sourceDecl{}, err
+		}
+		_, amount, err := p.parseMonetary()
+		if err != nil {
+			return sourceDecl{}, err
+		}
+		decl.Overdraft = amount
+	}
+
+	return decl, nil
+}
+
+func (p *parser) parseDestDecl() (destDecl, error) {
+	if p.peek().isKeyword("remaining") {
+		p.next()
+		if err := p.expectKeyword("to"); err != nil {
+			return destDecl{}, err
+		}
+		name, err := p.expectIdent()
+		if err != nil {
+			return destDecl{}, err
+		}
+		return destDecl{Account: name, Remaining: true}, nil
+	}
+
+	shareTok, err := p.expectKind(tokPercent, "долю вида N%")
+	if err != nil {
+		return destDecl{}, err
+	}
+	share, err := strconv.ParseFloat(shareTok.text, 64)
+	if err != nil {
+		return destDecl{}, fmt.Errorf("vm: некорректная доля %q", shareTok.text)
+	}
+	if err := p.expectKeyword("to"); err != nil {
+		return destDecl{}, err
+	}
+	name, err := p.expectIdent()
+	if err != nil {
+		return destDecl{}, err
+	}
+
+	return destDecl{Account: name, BasisPoints: int64(share * 100)}, nil
+}
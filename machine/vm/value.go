@@ -0,0 +1,35 @@
+// Package vm реализует небольшую виртуальную машину для DSL описания
+// переводов ("программа вместо одного From->To с суммой"): скрипт
+// компилируется в список опкодов, а Machine выполняет их шаг за шагом,
+// производя список проводок (Posting).
+package vm
+
+// ValueKind - тип значения, которым может быть переменная скрипта.
+type ValueKind int
+
+const (
+	KindAccount ValueKind = iota
+	KindMonetary
+)
+
+// Monetary - сумма в конкретной валюте.
+type Monetary struct {
+	Currency string
+	Amount   int64
+}
+
+// Value - значение переменной, переданной в Execute (имя счета или сумма).
+type Value struct {
+	Kind     ValueKind
+	Account  string
+	Monetary Monetary
+}
+
+// Posting - одна проводка, полученная в результате выполнения программы:
+// списание Amount валюты Currency со счета Source в пользу Destination.
+type Posting struct {
+	Source      string
+	Destination string
+	Currency    string
+	Amount      int64
+}
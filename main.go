@@ -2,50 +2,497 @@ package main
 
 import (
 	"bufio"
+	stdcrypto "crypto/ed25519"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/weeckee/function-7-8/crypto"
+	"github.com/weeckee/function-7-8/machine/vm"
 )
 
+// CoinbaseAccountID - ID единственного privileged-счета, уполномоченного
+// регистрировать валюты, довыпускать токены и включать/выключать глобальную
+// блокировку системы.
+const CoinbaseAccountID = "COINBASE"
+
 var (
 	ErrInsufficientFunds   = errors.New("недостаточно средств на счете")
 	ErrInvalidAmount       = errors.New("некорректная сумма")
+	ErrInvalidSymbol       = errors.New("некорректный символ валюты")
 	ErrAccountNotFound     = errors.New("счет не найден")
 	ErrSameAccountTransfer = errors.New("невозможно перевод на тот же счет")
+	ErrStorageConflict     = errors.New("конфликт состояния: параллельное изменение")
+	ErrTransferNotFound    = errors.New("перевод с таким референсом не найден")
+	ErrTransferTimedOut    = errors.New("превышено число попыток выполнения перевода")
+	ErrSystemLocked        = errors.New("система заблокирована администратором")
+	ErrCurrencyExists      = errors.New("валюта с таким символом уже зарегистрирована")
+	ErrCurrencyNotFound    = errors.New("валюта не зарегистрирована")
+	ErrInvalidSignature    = errors.New("подпись транзакции недействительна")
+	ErrInvalidSequence     = errors.New("неверный номер последовательности транзакции")
 )
 
 type Transaction struct {
 	Timestamp   time.Time
 	Type        string
-	Amount      float64
+	Currency    string
+	Amount      int64
 	From        string
 	To          string
 	Description string
 }
 
+// Account - счет с балансами в нескольких валютах одновременно: Balances
+// хранит остаток по каждому символу валюты отдельно, так что USD и токен
+// собственной эмиссии сосуществуют на одном счете, не смешиваясь. PubKey и
+// Sequence используются только для операций, авторизованных подписанными
+// транзакциями (SendTx/WithdrawTx) - Sequence растет на единицу с каждой
+// принятой транзакцией и защищает от повторного воспроизведения (replay)
+// ранее подписанной транзакции.
 type Account struct {
 	ID           string
 	Owner        string
-	Balance      float64
+	PubKey       crypto.PubKey
+	Sequence     uint64
+	Balances     map[string]int64
 	Transactions []Transaction
 }
 
 type AccountService interface {
-	Deposit(amount float64) error
-	Withdraw(amount float64) error
-	Transfer(to *Account, amount float64) error
-	GetBalance() float64
+	Deposit(symbol string, amount int64) error
+	Withdraw(symbol string, amount int64) error
+	GetBalance(symbol string) int64
 	GetStatement() string
+	Execute(script string, vars map[string]vm.Value) ([]vm.Posting, error)
+}
+
+// ScriptAccountService реализует AccountService для одного счета и, в
+// дополнение к обычным операциям, умеет выполнять скрипты пакета vm: в
+// отличие от Deposit/Withdraw, Execute затрагивает произвольное число
+// счетов, поэтому сервису нужен доступ к Storage, а не только к своему
+// Account. registry используется для проверки глобальной блокировки перед
+// любой изменяющей операцией.
+type ScriptAccountService struct {
+	account  *Account
+	storage  Storage
+	registry *CurrencyRegistry
+}
+
+func NewScriptAccountService(account *Account, storage Storage, registry *CurrencyRegistry) *ScriptAccountService {
+	return &ScriptAccountService{account: account, storage: storage, registry: registry}
+}
+
+func (s *ScriptAccountService) Deposit(symbol string, amount int64) error {
+	if s.registry.IsLocked() {
+		return ErrSystemLocked
+	}
+	return s.account.Deposit(symbol, amount)
+}
+
+func (s *ScriptAccountService) Withdraw(symbol string, amount int64) error {
+	if s.registry.IsLocked() {
+		return ErrSystemLocked
+	}
+	return s.account.Withdraw(symbol, amount)
+}
+
+func (s *ScriptAccountService) GetBalance(symbol string) int64 { return s.account.GetBalance(symbol) }
+func (s *ScriptAccountService) GetStatement() string           { return s.account.GetStatement() }
+
+// Execute компилирует и выполняет script, проверяя для каждого источника
+// реальный баланс через Storage (см. vm.Execute), а затем атомарно проводит
+// полученные постинги по счетам, добавляя в их историю те же типы
+// транзакций, что и обычный Transfer.
+func (s *ScriptAccountService) Execute(script string, vars map[string]vm.Value) ([]vm.Posting, error) {
+	if s.registry.IsLocked() {
+		return nil, ErrSystemLocked
+	}
+	postings, err := vm.Execute(script, vars, s.accountBalance)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyPostings(postings); err != nil {
+		return nil, err
+	}
+	return postings, nil
+}
+
+func (s *ScriptAccountService) accountBalance(accountID, currency string) (int64, error) {
+	acc, err := s.storage.LoadAccount(accountID)
+	if err != nil {
+		return 0, err
+	}
+	return acc.GetBalance(currency), nil
+}
+
+func (s *ScriptAccountService) applyPostings(postings []vm.Posting) error {
+	touched := make(map[string]*Account)
+	load := func(accountID string) (*Account, error) {
+		if acc, ok := touched[accountID]; ok {
+			return acc, nil
+		}
+		acc, err := s.storage.LoadAccount(accountID)
+		if err != nil {
+			return nil, err
+		}
+		// LoadAccount отдает указатель на хранимый счет, а не его копию, а
+		// скрипт должен применяться атомарно: если поздняя проводка упадет
+		// (например, нехватка средств), ранее обработанные проводки не
+		// должны успеть стать видимыми. clone откладывает видимость правки
+		// до итогового SaveAccount - см. clone().
+		acc = acc.clone()
+		touched[accountID] = acc
+		return acc, nil
+	}
+
+	for _, p := range postings {
+		from, err := load(p.Source)
+		if err != nil {
+			return err
+		}
+		to, err := load(p.Destination)
+		if err != nil {
+			return err
+		}
+
+		if err := from.debit(p.Currency, p.Amount, to.ID, fmt.Sprintf("Перевод по скрипту на счет %s: %d %s", to.ID, p.Amount, p.Currency)); err != nil {
+			return err
+		}
+		if err := to.credit(p.Currency, p.Amount, from.ID, fmt.Sprintf("Перевод по скрипту от счета %s: %d %s", from.ID, p.Amount, p.Currency)); err != nil {
+			return err
+		}
+	}
+
+	for _, acc := range touched {
+		if err := s.storage.SaveAccount(acc); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Storage interface {
 	SaveAccount(account *Account) error
 	LoadAccount(accountID string) (*Account, error)
 	GetAllAccounts() ([]*Account, error)
+	ApplySendTx(tx *SendTx) error
+	ApplyWithdrawTx(tx *WithdrawTx) error
+}
+
+// SendTx - подписанное поручение перевести средства с одного счета на
+// другой. В отличие от обычного Transfer (см. TransferCoordinator), SendTx
+// применяется немедленно и атомарно: подпись доказывает, что поручение
+// исходит от владельца From, а Sequence защищает от повторного применения
+// одной и той же подписанной транзакции (replay).
+type SendTx struct {
+	From      string
+	To        string
+	Symbol    string
+	Amount    int64
+	Sequence  uint64
+	Signature crypto.Signature
+}
+
+// SignBytes возвращает каноническое представление транзакции без подписи -
+// именно эти байты должны быть подписаны отправителем и именно их проверяет
+// Storage.
+func (tx *SendTx) SignBytes() []byte {
+	return []byte(fmt.Sprintf("SendTx|%s|%s|%s|%d|%d", tx.From, tx.To, tx.Symbol, tx.Amount, tx.Sequence))
+}
+
+// WithdrawTx - подписанное поручение снять средства со счета From.
+type WithdrawTx struct {
+	From      string
+	Symbol    string
+	Amount    int64
+	Sequence  uint64
+	Signature crypto.Signature
+}
+
+// SignBytes возвращает каноническое представление транзакции без подписи.
+func (tx *WithdrawTx) SignBytes() []byte {
+	return []byte(fmt.Sprintf("WithdrawTx|%s|%s|%d|%d", tx.From, tx.Symbol, tx.Amount, tx.Sequence))
+}
+
+// InitCurrencyTx - подписанное поручение привилегированного счета coinbase
+// зарегистрировать новую валюту. Проверяется так же, как SendTx/WithdrawTx:
+// открытым ключом счета COINBASE и номером Sequence этого счета.
+type InitCurrencyTx struct {
+	Name          string
+	Symbol        string
+	InitialSupply int64
+	Sequence      uint64
+	Signature     crypto.Signature
+}
+
+// SignBytes возвращает каноническое представление транзакции без подписи.
+func (tx *InitCurrencyTx) SignBytes() []byte {
+	return []byte(fmt.Sprintf("InitCurrencyTx|%s|%s|%d|%d", tx.Name, tx.Symbol, tx.InitialSupply, tx.Sequence))
+}
+
+// MintTx - подписанное поручение coinbase довыпустить amount единиц уже
+// зарегистрированной валюты Symbol.
+type MintTx struct {
+	Symbol    string
+	Amount    int64
+	Sequence  uint64
+	Signature crypto.Signature
+}
+
+// SignBytes возвращает каноническое представление транзакции без подписи.
+func (tx *MintTx) SignBytes() []byte {
+	return []byte(fmt.Sprintf("MintTx|%s|%d|%d", tx.Symbol, tx.Amount, tx.Sequence))
+}
+
+// SetLockTx - подписанное поручение coinbase включить или выключить
+// глобальную блокировку системы.
+type SetLockTx struct {
+	Locked    bool
+	Sequence  uint64
+	Signature crypto.Signature
+}
+
+// SignBytes возвращает каноническое представление транзакции без подписи.
+func (tx *SetLockTx) SignBytes() []byte {
+	return []byte(fmt.Sprintf("SetLockTx|%t|%d", tx.Locked, tx.Sequence))
+}
+
+// Currency - валюта или токен, зарегистрированный privileged-счетом
+// coinbase.
+type Currency struct {
+	Name          string
+	Symbol        string
+	InitialSupply int64
+	OwnerID       string
+}
+
+// CurrencyRegistry управляет набором валют и глобальной блокировкой системы.
+// Обе группы операций доступны только тому, кто докажет владение приватным
+// ключом счета CoinbaseAccountID: каждый метод принимает подписанную
+// транзакцию (InitCurrencyTx/MintTx/SetLockTx) и проверяет ее так же, как
+// Storage проверяет SendTx/WithdrawTx - открытым ключом счета и номером
+// Sequence, защищающим от повторного применения.
+type CurrencyRegistry struct {
+	mutex      sync.RWMutex
+	currencies map[string]*Currency
+	locked     bool
+}
+
+func NewCurrencyRegistry() *CurrencyRegistry {
+	return &CurrencyRegistry{
+		currencies: make(map[string]*Currency),
+	}
+}
+
+// IsLocked сообщает, включена ли сейчас глобальная блокировка системы.
+func (cr *CurrencyRegistry) IsLocked() bool {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.locked
+}
+
+// SetLock проверяет подпись tx открытым ключом счета COINBASE и, только
+// если она верна, включает или выключает глобальную блокировку: пока она
+// включена, любая изменяющая операция (Deposit, Withdraw, перевод, Execute,
+// минтинг) возвращает ErrSystemLocked.
+func (cr *CurrencyRegistry) SetLock(storage Storage, tx *SetLockTx) error {
+	coinbase, err := storage.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		return err
+	}
+	if err := verifySequencedTx(coinbase, tx.SignBytes(), tx.Signature, tx.Sequence); err != nil {
+		return err
+	}
+
+	cr.mutex.Lock()
+	cr.locked = tx.Locked
+	cr.mutex.Unlock()
+
+	coinbase.Sequence = tx.Sequence
+	return storage.SaveAccount(coinbase)
+}
+
+// InitCurrency проверяет подпись tx открытым ключом счета COINBASE и, только
+// если она верна, регистрирует новую валюту с символом tx.Symbol и зачисляет
+// ее начальную эмиссию на privileged-счет coinbase.
+func (cr *CurrencyRegistry) InitCurrency(storage Storage, tx *InitCurrencyTx) (*Currency, error) {
+	coinbase, err := storage.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySequencedTx(coinbase, tx.SignBytes(), tx.Signature, tx.Sequence); err != nil {
+		return nil, err
+	}
+	if cr.IsLocked() {
+		return nil, ErrSystemLocked
+	}
+	if tx.Symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+	if tx.InitialSupply < 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	cr.mutex.Lock()
+	if _, exists := cr.currencies[tx.Symbol]; exists {
+		cr.mutex.Unlock()
+		return nil, ErrCurrencyExists
+	}
+	currency := &Currency{Name: tx.Name, Symbol: tx.Symbol, InitialSupply: tx.InitialSupply, OwnerID: CoinbaseAccountID}
+	cr.currencies[tx.Symbol] = currency
+	cr.mutex.Unlock()
+
+	if tx.InitialSupply > 0 {
+		if err := coinbase.Deposit(tx.Symbol, tx.InitialSupply); err != nil {
+			return nil, err
+		}
+	}
+	coinbase.Sequence = tx.Sequence
+	if err := storage.SaveAccount(coinbase); err != nil {
+		return nil, err
+	}
+
+	return currency, nil
+}
+
+// MintToken проверяет подпись tx открытым ключом счета COINBASE и, только
+// если она верна, довыпускает tx.Amount единиц уже зарегистрированной
+// валюты tx.Symbol на privileged-счет coinbase.
+func (cr *CurrencyRegistry) MintToken(storage Storage, tx *MintTx) error {
+	coinbase, err := storage.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		return err
+	}
+	if err := verifySequencedTx(coinbase, tx.SignBytes(), tx.Signature, tx.Sequence); err != nil {
+		return err
+	}
+	if cr.IsLocked() {
+		return ErrSystemLocked
+	}
+	if tx.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	cr.mutex.RLock()
+	_, exists := cr.currencies[tx.Symbol]
+	cr.mutex.RUnlock()
+	if !exists {
+		return ErrCurrencyNotFound
+	}
+
+	if err := coinbase.Deposit(tx.Symbol, tx.Amount); err != nil {
+		return err
+	}
+	coinbase.Sequence = tx.Sequence
+	return storage.SaveAccount(coinbase)
+}
+
+// TransferStatus - стадия саги перевода средств между счетами.
+type TransferStatus string
+
+const (
+	TransferStarted     TransferStatus = "STARTED"
+	TransferWithdrawing TransferStatus = "WITHDRAWING"
+	TransferDepositing  TransferStatus = "DEPOSITING"
+	TransferRefunding   TransferStatus = "REFUNDING"
+	TransferSucceeded   TransferStatus = "SUCCEEDED"
+	TransferFailed      TransferStatus = "FAILED"
+)
+
+// TransferRequest - заявка на перевод, идентифицируемая референсом вызывающей
+// стороны. Повторная отправка заявки с тем же ReferenceID не приводит к
+// повторному списанию: коллектор переиспользует уже сохраненное состояние.
+// TransferRequest описывает перевод Amount валюты Symbol. FeeTo/FeeAmount
+// необязательны: если FeeAmount > 0, коллектор дополнительно списывает
+// FeeAmount с отправителя в пользу FeeTo в том же шаге, что и зачисление
+// получателю.
+type TransferRequest struct {
+	ReferenceID string
+	From        string
+	To          string
+	Symbol      string
+	Amount      int64
+	FeeTo       string
+	FeeAmount   int64
+}
+
+// TransferState - персистентное состояние саги перевода. Коллектор проводит
+// его через цепочку Started -> Withdrawing -> Depositing -> Succeeded, либо,
+// при ошибке на любом из шагов, через Refunding -> Failed. Claimed отличает
+// статус, за выполнение которого уже кто-то отвечает, от статуса, чья
+// мутация балансов еще не начата - см. run(). У claim нет lease/таймаута:
+// застрявший в Claimed=true статус (например, из-за повреждения
+// персистентного состояния в обход run()) не восстанавливается сам по себе.
+type TransferState struct {
+	ReferenceID string
+	From        string
+	To          string
+	Symbol      string
+	Amount      int64
+	FeeTo       string
+	FeeAmount   int64
+	Status      TransferStatus
+	Claimed     bool
+	Error       string
+}
+
+// Persistence хранит состояние саг переводов отдельно от балансов счетов,
+// чтобы рестарт процесса или повторная отправка той же заявки сходились
+// ровно к одному примененному переводу.
+type Persistence interface {
+	Load(key string) (*TransferState, error)
+	CompareAndSwap(key string, new *TransferState, expected *TransferState) error
+}
+
+type MemoryPersistence struct {
+	states map[string]*TransferState
+	mutex  sync.RWMutex
+}
+
+func NewMemoryPersistence() *MemoryPersistence {
+	return &MemoryPersistence{
+		states: make(map[string]*TransferState),
+	}
+}
+
+func (mp *MemoryPersistence) Load(key string) (*TransferState, error) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	state, exists := mp.states[key]
+	if !exists {
+		return nil, ErrTransferNotFound
+	}
+	stateCopy := *state
+	return &stateCopy, nil
+}
+
+// CompareAndSwap заменяет состояние на new только если текущее значение
+// совпадает с expected (nil expected означает "ключа еще не существует").
+// В противном случае возвращается ErrStorageConflict, и вызывающая сторона
+// обязана перечитать состояние через Load и повторить шаг саги.
+func (mp *MemoryPersistence) CompareAndSwap(key string, new *TransferState, expected *TransferState) error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	current, exists := mp.states[key]
+	if expected == nil {
+		if exists {
+			return ErrStorageConflict
+		}
+	} else if !exists || *current != *expected {
+		return ErrStorageConflict
+	}
+
+	stored := *new
+	mp.states[key] = &stored
+	return nil
 }
 
 type MemoryStorage struct {
@@ -88,103 +535,496 @@ func (ms *MemoryStorage) GetAllAccounts() ([]*Account, error) {
 	return accounts, nil
 }
 
-func (acc *Account) Deposit(amount float64) error {
+// ApplySendTx проверяет подпись и номер последовательности tx и, только если
+// они верны, списывает средства со счета From и зачисляет их на счет To.
+func (ms *MemoryStorage) ApplySendTx(tx *SendTx) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if tx.From == tx.To {
+		return ErrSameAccountTransfer
+	}
+
+	from, exists := ms.accounts[tx.From]
+	if !exists {
+		return ErrAccountNotFound
+	}
+	to, exists := ms.accounts[tx.To]
+	if !exists {
+		return ErrAccountNotFound
+	}
+	if err := verifySequencedTx(from, tx.SignBytes(), tx.Signature, tx.Sequence); err != nil {
+		return err
+	}
+
+	if err := from.Withdraw(tx.Symbol, tx.Amount); err != nil {
+		return err
+	}
+	if err := to.Deposit(tx.Symbol, tx.Amount); err != nil {
+		if refundErr := from.Deposit(tx.Symbol, tx.Amount); refundErr != nil {
+			return fmt.Errorf("%w (возврат средств тоже не удался: %v)", err, refundErr)
+		}
+		return err
+	}
+	from.Sequence = tx.Sequence
+	return nil
+}
+
+// ApplyWithdrawTx проверяет подпись и номер последовательности tx и, только
+// если они верны, списывает средства со счета From.
+func (ms *MemoryStorage) ApplyWithdrawTx(tx *WithdrawTx) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	from, exists := ms.accounts[tx.From]
+	if !exists {
+		return ErrAccountNotFound
+	}
+	if err := verifySequencedTx(from, tx.SignBytes(), tx.Signature, tx.Sequence); err != nil {
+		return err
+	}
+
+	if err := from.Withdraw(tx.Symbol, tx.Amount); err != nil {
+		return err
+	}
+	from.Sequence = tx.Sequence
+	return nil
+}
+
+// verifySequencedTx проверяет подпись произвольной транзакции открытым
+// ключом account и отклоняет ее, если sequence не равен account.Sequence+1 -
+// это единственный номер, который когда-либо будет принят дальше, так что
+// одну и ту же подписанную транзакцию нельзя применить повторно.
+func verifySequencedTx(account *Account, signBytes []byte, signature crypto.Signature, sequence uint64) error {
+	if !crypto.Verify(account.PubKey, signBytes, signature) {
+		return ErrInvalidSignature
+	}
+	if sequence != account.Sequence+1 {
+		return ErrInvalidSequence
+	}
+	return nil
+}
+
+func (acc *Account) Deposit(symbol string, amount int64) error {
+	if symbol == "" {
+		return ErrInvalidSymbol
+	}
 	if amount <= 0 {
 		return ErrInvalidAmount
 	}
 
-	acc.Balance += amount
+	if acc.Balances == nil {
+		acc.Balances = make(map[string]int64)
+	}
+	acc.Balances[symbol] += amount
 	acc.Transactions = append(acc.Transactions, Transaction{
 		Timestamp:   time.Now(),
 		Type:        "ПОПОЛНЕНИЕ",
+		Currency:    symbol,
 		Amount:      amount,
 		To:          acc.ID,
-		Description: fmt.Sprintf("Пополнение счета на %.2f", amount),
+		Description: fmt.Sprintf("Пополнение счета на %d %s", amount, symbol),
 	})
 	return nil
 }
 
-func (acc *Account) Withdraw(amount float64) error {
+func (acc *Account) Withdraw(symbol string, amount int64) error {
+	if symbol == "" {
+		return ErrInvalidSymbol
+	}
 	if amount <= 0 {
 		return ErrInvalidAmount
 	}
-	if acc.Balance < amount {
+	if acc.Balances[symbol] < amount {
 		return ErrInsufficientFunds
 	}
 
-	acc.Balance -= amount
+	acc.Balances[symbol] -= amount
 	acc.Transactions = append(acc.Transactions, Transaction{
 		Timestamp:   time.Now(),
 		Type:        "СНЯТИЕ",
+		Currency:    symbol,
 		Amount:      amount,
 		From:        acc.ID,
-		Description: fmt.Sprintf("Снятие со счета %.2f", amount),
+		Description: fmt.Sprintf("Снятие со счета %d %s", amount, symbol),
 	})
 	return nil
 }
 
-func (acc *Account) Transfer(to *Account, amount float64) error {
+// debit списывает amount у acc в пользу counterparty и фиксирует это записью
+// "ПЕРЕВОД" с заполненными From/To - в отличие от Withdraw, которая не знает
+// контрагента, debit используется там, где он известен (сага переводов,
+// скрипты DSL), чтобы выписка называла, с кем был перевод.
+func (acc *Account) debit(symbol string, amount int64, counterparty, description string) error {
+	if symbol == "" {
+		return ErrInvalidSymbol
+	}
 	if amount <= 0 {
 		return ErrInvalidAmount
 	}
-	if acc.Balance < amount {
+	if acc.Balances[symbol] < amount {
 		return ErrInsufficientFunds
 	}
-	if acc.ID == to.ID {
-		return ErrSameAccountTransfer
-	}
-
-	acc.Balance -= amount
-	to.Balance += amount
 
+	acc.Balances[symbol] -= amount
 	acc.Transactions = append(acc.Transactions, Transaction{
 		Timestamp:   time.Now(),
 		Type:        "ПЕРЕВОД",
+		Currency:    symbol,
 		Amount:      amount,
 		From:        acc.ID,
-		To:          to.ID,
-		Description: fmt.Sprintf("Перевод на счет %s: %.2f", to.ID, amount),
+		To:          counterparty,
+		Description: description,
 	})
+	return nil
+}
 
-	to.Transactions = append(to.Transactions, Transaction{
+// credit зачисляет amount на acc от counterparty и фиксирует это записью
+// "ЗАЧИСЛЕНИЕ" с заполненными From/To - зеркальная пара к debit.
+func (acc *Account) credit(symbol string, amount int64, counterparty, description string) error {
+	if symbol == "" {
+		return ErrInvalidSymbol
+	}
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	if acc.Balances == nil {
+		acc.Balances = make(map[string]int64)
+	}
+	acc.Balances[symbol] += amount
+	acc.Transactions = append(acc.Transactions, Transaction{
 		Timestamp:   time.Now(),
 		Type:        "ЗАЧИСЛЕНИЕ",
+		Currency:    symbol,
 		Amount:      amount,
-		From:        acc.ID,
-		To:          to.ID,
-		Description: fmt.Sprintf("Перевод от счета %s: %.2f", acc.ID, amount),
+		From:        counterparty,
+		To:          acc.ID,
+		Description: description,
 	})
-
 	return nil
 }
 
-func (acc *Account) GetBalance() float64 {
-	return acc.Balance
+func (acc *Account) GetBalance(symbol string) int64 {
+	return acc.Balances[symbol]
+}
+
+// clone возвращает независимую копию acc, с отдельными Balances и
+// Transactions: LoadAccount в MemoryStorage отдает указатель на хранимый
+// счет, а не его копию, поэтому правки поверх него видны сразу, еще до
+// SaveAccount. clone нужен там, где правка должна оставаться невидимой,
+// пока не подтверждена целиком (например, сага переводов при зачислении
+// получателю и держателю комиссии).
+func (acc *Account) clone() *Account {
+	cp := *acc
+	cp.Balances = make(map[string]int64, len(acc.Balances))
+	for symbol, amount := range acc.Balances {
+		cp.Balances[symbol] = amount
+	}
+	cp.Transactions = append([]Transaction(nil), acc.Transactions...)
+	return &cp
 }
 
+// GetStatement группирует историю операций по валюте: у каждого счета может
+// быть несколько балансов одновременно, и смешивать их суммы в одной
+// выписке было бы бессмысленно.
 func (acc *Account) GetStatement() string {
 	if len(acc.Transactions) == 0 {
-		return fmt.Sprintf("Выписка по счету %s\nВладелец: %s\nБаланс: %.2f\nИстория транзакций: нет операций\n",
-			acc.ID, acc.Owner, acc.Balance)
+		return fmt.Sprintf("Выписка по счету %s\nВладелец: %s\nИстория транзакций: нет операций\n",
+			acc.ID, acc.Owner)
+	}
+
+	var currencies []string
+	grouped := make(map[string][]Transaction)
+	seen := make(map[string]bool)
+	for _, tx := range acc.Transactions {
+		if !seen[tx.Currency] {
+			seen[tx.Currency] = true
+			currencies = append(currencies, tx.Currency)
+		}
+		grouped[tx.Currency] = append(grouped[tx.Currency], tx)
 	}
 
 	statement := fmt.Sprintf("ВЫПИСКА ПО СЧЕТУ %s\n", acc.ID)
 	statement += fmt.Sprintf("Владелец: %s\n", acc.Owner)
-	statement += fmt.Sprintf("Текущий баланс: %.2f\n", acc.Balance)
-	statement += "─────────────────────────────────────\n"
-	statement += "ДАТА И ВРЕМЯ        | ТИП ОПЕРАЦИИ | СУММА  | ОПИСАНИЕ\n"
-	statement += "─────────────────────────────────────\n"
 
-	for _, tx := range acc.Transactions {
-		statement += fmt.Sprintf("%s | %-12s | %6.2f | %s\n",
-			tx.Timestamp.Format("02.01.2006 15:04"),
-			tx.Type,
-			tx.Amount,
-			tx.Description)
+	for _, currency := range currencies {
+		statement += "─────────────────────────────────────\n"
+		statement += fmt.Sprintf("ВАЛЮТА: %s | Баланс: %d\n", currency, acc.Balances[currency])
+		statement += "ДАТА И ВРЕМЯ        | ТИП ОПЕРАЦИИ | СУММА  | ОПИСАНИЕ\n"
+		for _, tx := range grouped[currency] {
+			statement += fmt.Sprintf("%s | %-12s | %6d | %s\n",
+				tx.Timestamp.Format("02.01.2006 15:04"),
+				tx.Type,
+				tx.Amount,
+				tx.Description)
+		}
 	}
 	return statement
 }
 
+const maxTransferAttempts = 5
+
+// maxClaimWaitAttempts - сколько раз перечитать состояние, ожидая, пока
+// владелец claim снимет его, прежде чем сдаться. Отдельно от
+// maxTransferAttempts: иначе чужое ожидание и собственный повтор коммита
+// владельца делили бы один и тот же бюджет попыток, и ожидающий мог бы
+// получить ErrTransferTimedOut для перевода, который на самом деле
+// благополучно завершился чуть позже.
+const maxClaimWaitAttempts = 20
+
+// TransferCoordinator проводит перевод между счетами как сагу: списание и
+// зачисление выполняются отдельными шагами с персистентным статусом, так что
+// сбой процесса или повторная отправка той же заявки (по ReferenceID)
+// сходятся ровно к одному примененному переводу вместо дублирования.
+type TransferCoordinator struct {
+	storage     Storage
+	persistence Persistence
+	registry    *CurrencyRegistry
+}
+
+func NewTransferCoordinator(storage Storage, persistence Persistence, registry *CurrencyRegistry) *TransferCoordinator {
+	return &TransferCoordinator{
+		storage:     storage,
+		persistence: persistence,
+		registry:    registry,
+	}
+}
+
+// Submit регистрирует заявку на перевод и проводит сагу до терминального
+// статуса (Succeeded или Failed). Если заявка с таким ReferenceID уже
+// обрабатывалась, Submit не списывает средства повторно, а возвращает
+// результат, к которому саге суждено сойтись.
+func (tc *TransferCoordinator) Submit(req TransferRequest) (*TransferState, error) {
+	if tc.registry.IsLocked() {
+		return nil, ErrSystemLocked
+	}
+	if req.Symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+	if req.Amount <= 0 || req.FeeAmount < 0 {
+		return nil, ErrInvalidAmount
+	}
+	if req.From == req.To {
+		return nil, ErrSameAccountTransfer
+	}
+
+	state, err := tc.persistence.Load(req.ReferenceID)
+	if err != nil {
+		if !errors.Is(err, ErrTransferNotFound) {
+			return nil, err
+		}
+
+		started := &TransferState{
+			ReferenceID: req.ReferenceID,
+			From:        req.From,
+			To:          req.To,
+			Symbol:      req.Symbol,
+			Amount:      req.Amount,
+			FeeTo:       req.FeeTo,
+			FeeAmount:   req.FeeAmount,
+			Status:      TransferStarted,
+		}
+		if err := tc.persistence.CompareAndSwap(req.ReferenceID, started, nil); err != nil {
+			if !errors.Is(err, ErrStorageConflict) {
+				return nil, err
+			}
+			// Проиграли гонку за создание состояния: доводим сагу,
+			// созданную параллельным отправителем той же заявки.
+		}
+
+		state, err = tc.persistence.Load(req.ReferenceID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tc.run(state)
+}
+
+// Status возвращает текущее состояние ранее отправленной заявки по ее
+// ReferenceID, не продвигая сагу дальше.
+func (tc *TransferCoordinator) Status(referenceID string) (*TransferState, error) {
+	return tc.persistence.Load(referenceID)
+}
+
+// run продвигает сагу шаг за шагом до терминального статуса, перечитывая
+// состояние и повторяя шаг при ErrStorageConflict от Persistence.
+//
+// Каждый нетерминальный статус сначала нужно застолбить: CAS переводит
+// Claimed в true, прежде чем step() тронет балансы счетов. Это разделяет
+// "я выиграл право выполнить мутацию для этого статуса" от "кто-то другой
+// уже ее выполняет" - без этого два вызова run() над одним и тем же
+// перечитанным статусом (гонка CAS-проигравшего, либо дублирующийся/
+// повторный Submit той же еще не завершенной заявки) независимо повторили
+// бы debit/credit/refund. Если Claimed уже true, мутация не наша - ждем,
+// перечитывая состояние, пока ее не завершит владелец.
+func (tc *TransferCoordinator) run(state *TransferState) (*TransferState, error) {
+	for attempt := 0; attempt < maxTransferAttempts; attempt++ {
+		if state.Status == TransferSucceeded || state.Status == TransferFailed {
+			return state, nil
+		}
+
+		if state.Claimed {
+			waited := false
+			for i := 0; i < maxClaimWaitAttempts; i++ {
+				reloaded, err := tc.persistence.Load(state.ReferenceID)
+				if err != nil {
+					return nil, err
+				}
+				state = reloaded
+				if !state.Claimed {
+					waited = true
+					break
+				}
+			}
+			if !waited {
+				return state, ErrTransferTimedOut
+			}
+			continue
+		}
+
+		claim := *state
+		claim.Claimed = true
+		if err := tc.persistence.CompareAndSwap(state.ReferenceID, &claim, state); err != nil {
+			if !errors.Is(err, ErrStorageConflict) {
+				return nil, err
+			}
+			reloaded, loadErr := tc.persistence.Load(state.ReferenceID)
+			if loadErr != nil {
+				return nil, loadErr
+			}
+			state = reloaded
+			continue
+		}
+
+		// step() уже применил мутацию к Storage - отступать к общей логике
+		// выше (перечитать и проверить Claimed) больше нельзя: пока наш claim
+		// не снят, никто другой не смог бы выиграть свой claim-CAS, так что
+		// перечитанное значение - это все тот же claim, который мы только
+		// что сами застолбили, а не чужая мутация, которую нужно ждать.
+		// Поэтому коммит next повторяем напрямую, пока он не пройдет.
+		next := tc.step(&claim)
+		next.Claimed = false
+
+		var commitErr error
+		for i := 0; i < maxTransferAttempts; i++ {
+			if commitErr = tc.persistence.CompareAndSwap(state.ReferenceID, next, &claim); commitErr == nil {
+				break
+			}
+		}
+		if commitErr != nil {
+			return nil, commitErr
+		}
+		state = next
+	}
+
+	return state, ErrTransferTimedOut
+}
+
+// step выполняет ровно один переход саги и возвращает следующее состояние.
+// Бизнес-ошибки (например, недостаточно средств) не прерывают сагу, а
+// переводят ее в Refunding/Failed с описанием причины в TransferState.Error.
+func (tc *TransferCoordinator) step(state *TransferState) *TransferState {
+	next := *state
+
+	switch state.Status {
+	case TransferStarted:
+		next.Status = TransferWithdrawing
+
+	case TransferWithdrawing:
+		from, err := tc.storage.LoadAccount(state.From)
+		if err != nil {
+			next.Status = TransferFailed
+			next.Error = err.Error()
+			break
+		}
+		if from.GetBalance(state.Symbol) < state.Amount+state.FeeAmount {
+			next.Status = TransferFailed
+			next.Error = ErrInsufficientFunds.Error()
+			break
+		}
+		if err := from.debit(state.Symbol, state.Amount, state.To,
+			fmt.Sprintf("Перевод на счет %s: %d %s", state.To, state.Amount, state.Symbol)); err != nil {
+			next.Status = TransferFailed
+			next.Error = err.Error()
+			break
+		}
+		if state.FeeAmount > 0 {
+			if err := from.debit(state.Symbol, state.FeeAmount, state.FeeTo,
+				fmt.Sprintf("Комиссия за перевод на счет %s: %d %s", state.To, state.FeeAmount, state.Symbol)); err != nil {
+				next.Status = TransferFailed
+				next.Error = err.Error()
+				break
+			}
+		}
+		tc.storage.SaveAccount(from)
+		next.Status = TransferDepositing
+
+	case TransferDepositing:
+		toStored, err := tc.storage.LoadAccount(state.To)
+		if err != nil {
+			next.Status = TransferRefunding
+			next.Error = err.Error()
+			break
+		}
+		// credit правится поверх clone(), а не toStored напрямую: LoadAccount
+		// отдает указатель на хранимый счет, и правка на нем была бы видна
+		// сразу, еще до SaveAccount ниже - clone откладывает видимость
+		// зачисления до момента, когда оно уже точно не будет отменено.
+		to := toStored.clone()
+
+		// Держатель комиссии загружается и проверяется до зачисления
+		// получателю: иначе при отсутствующем FeeTo получатель уже был бы
+		// зачислен, а саге пришлось бы частично откатываться.
+		var feeAccount *Account
+		if state.FeeAmount > 0 {
+			feeStored, err := tc.storage.LoadAccount(state.FeeTo)
+			if err != nil {
+				next.Status = TransferRefunding
+				next.Error = err.Error()
+				break
+			}
+			feeAccount = feeStored.clone()
+		}
+
+		// Оба зачисления (получателю и держателю комиссии) проводятся на
+		// клонах и сохраняются в Storage только после того, как обе
+		// успешны - так ни одно из них не может стать видимым, пока второе
+		// провалилось: Refunding ниже всегда откатывает from целиком, зная,
+		// что на стороне депозита либо зачислено все, либо ничего.
+		if err := to.credit(state.Symbol, state.Amount, state.From,
+			fmt.Sprintf("Перевод от счета %s: %d %s", state.From, state.Amount, state.Symbol)); err != nil {
+			next.Status = TransferRefunding
+			next.Error = err.Error()
+			break
+		}
+
+		if feeAccount != nil {
+			if err := feeAccount.credit(state.Symbol, state.FeeAmount, state.From,
+				fmt.Sprintf("Комиссия за перевод от счета %s: %d %s", state.From, state.FeeAmount, state.Symbol)); err != nil {
+				next.Status = TransferRefunding
+				next.Error = err.Error()
+				break
+			}
+			tc.storage.SaveAccount(feeAccount)
+		}
+		tc.storage.SaveAccount(to)
+
+		next.Status = TransferSucceeded
+
+	case TransferRefunding:
+		from, err := tc.storage.LoadAccount(state.From)
+		if err == nil {
+			from.Deposit(state.Symbol, state.Amount+state.FeeAmount)
+			tc.storage.SaveAccount(from)
+		}
+		next.Status = TransferFailed
+	}
+
+	return &next
+}
+
 func showMainMenu() {
 	fmt.Println("\n═══════════════════════════════════")
 	fmt.Println("           ГЛАВНОЕ МЕНЮ")
@@ -192,7 +1032,8 @@ func showMainMenu() {
 	fmt.Println("1. Создать новый счет")
 	fmt.Println("2. Работа с существующим счетом")
 	fmt.Println("3. Список всех счетов")
-	fmt.Println("4. Выйти")
+	fmt.Println("4. Администрирование (coinbase)")
+	fmt.Println("5. Выйти")
 	fmt.Print("Выберите опцию: ")
 }
 
@@ -210,14 +1051,20 @@ func createAccount(store *MemoryStorage, scanner *bufio.Scanner) {
 	accounts, _ := store.GetAllAccounts()
 	newID := fmt.Sprintf("ACC%04d", len(accounts)+1)
 
+	priv, err := crypto.GenPrivAccount()
+	if err != nil {
+		fmt.Println("❌ Ошибка при генерации ключей счета:", err)
+		return
+	}
+
 	account := &Account{
-		ID:      newID,
-		Owner:   owner,
-		Balance: 0,
+		ID:       newID,
+		Owner:    owner,
+		PubKey:   priv.PubKey,
+		Balances: make(map[string]int64),
 	}
 
-	err := store.SaveAccount(account)
-	if err != nil {
+	if err := store.SaveAccount(account); err != nil {
 		fmt.Println("❌ Ошибка при создании счета:", err)
 		return
 	}
@@ -225,10 +1072,12 @@ func createAccount(store *MemoryStorage, scanner *bufio.Scanner) {
 	fmt.Printf("✅ Счет создан успешно!\n")
 	fmt.Printf("   ID счета: %s\n", newID)
 	fmt.Printf("   Владелец: %s\n", owner)
-	fmt.Printf("   Начальный баланс: 0.00\n")
+	fmt.Println("⚠️  Сохраните приватный ключ - он больше не будет показан и нужен")
+	fmt.Println("   для авторизации снятия/перевода по подписи (см. пункт меню счета):")
+	fmt.Printf("   %s\n", hex.EncodeToString(priv.Key[:]))
 }
 
-func selectAccountMenu(store *MemoryStorage, scanner *bufio.Scanner) {
+func selectAccountMenu(store *MemoryStorage, coordinator *TransferCoordinator, registry *CurrencyRegistry, scanner *bufio.Scanner) {
 	fmt.Println("\n--- ВЫБОР СЧЕТА ---")
 	fmt.Print("Введите ID счета: ")
 	scanner.Scan()
@@ -241,20 +1090,22 @@ func selectAccountMenu(store *MemoryStorage, scanner *bufio.Scanner) {
 	}
 
 	fmt.Printf("✅ Счет найден: %s (%s)\n", account.ID, account.Owner)
-	accountOperations(store, scanner, account)
+	accountOperations(store, coordinator, registry, scanner, account)
 }
 
-func accountOperations(store *MemoryStorage, scanner *bufio.Scanner, account *Account) {
+func accountOperations(store *MemoryStorage, coordinator *TransferCoordinator, registry *CurrencyRegistry, scanner *bufio.Scanner, account *Account) {
 	for {
 		fmt.Println("\n═══════════════════════════════════")
 		fmt.Printf("СЧЕТ: %s | Владелец: %s\n", account.ID, account.Owner)
-		fmt.Printf("Баланс: %.2f\n", account.GetBalance())
+		fmt.Print(formatBalances(account.Balances))
 		fmt.Println("═══════════════════════════════════")
 		fmt.Println("1. Пополнить счет")
 		fmt.Println("2. Снять средства")
 		fmt.Println("3. Перевести другому счету")
 		fmt.Println("4. Просмотреть выписку")
-		fmt.Println("5. Вернуться в главное меню")
+		fmt.Println("5. Выполнить скрипт перевода (DSL)")
+		fmt.Println("6. Снять/перевести по подписи приватным ключом")
+		fmt.Println("7. Вернуться в главное меню")
 		fmt.Print("Выберите опцию: ")
 
 		scanner.Scan()
@@ -262,14 +1113,18 @@ func accountOperations(store *MemoryStorage, scanner *bufio.Scanner, account *Ac
 
 		switch input {
 		case "1":
-			deposit(store, scanner, account)
+			deposit(store, registry, scanner, account)
 		case "2":
-			withdraw(store, scanner, account)
+			withdraw(store, registry, scanner, account)
 		case "3":
-			transfer(store, scanner, account)
+			transfer(coordinator, scanner, account)
 		case "4":
 			getStatement(account)
 		case "5":
+			scriptTransfer(store, registry, scanner, account)
+		case "6":
+			signedOperation(store, registry, scanner, account)
+		case "7":
 			return
 		default:
 			fmt.Println("❌ Неверная опция")
@@ -277,43 +1132,55 @@ func accountOperations(store *MemoryStorage, scanner *bufio.Scanner, account *Ac
 	}
 }
 
-func deposit(store *MemoryStorage, scanner *bufio.Scanner, account *Account) {
-	amount, err := getAmount(scanner, "Введите сумму для пополнения: ")
+func deposit(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner, account *Account) {
+	if registry.IsLocked() {
+		fmt.Println("❌ Ошибка:", ErrSystemLocked)
+		return
+	}
+
+	symbol := getSymbol(scanner, "Введите символ валюты: ")
+	amount, err := getIntAmount(scanner, "Введите сумму для пополнения: ")
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
 
-	err = account.Deposit(amount)
+	err = account.Deposit(symbol, amount)
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
 
 	store.SaveAccount(account)
-	fmt.Printf("✅ Пополнение на %.2f прошло успешно\n", amount)
-	fmt.Printf("   Новый баланс: %.2f\n", account.GetBalance())
+	fmt.Printf("✅ Пополнение на %d %s прошло успешно\n", amount, symbol)
+	fmt.Printf("   Новый баланс: %d %s\n", account.GetBalance(symbol), symbol)
 }
 
-func withdraw(store *MemoryStorage, scanner *bufio.Scanner, account *Account) {
-	amount, err := getAmount(scanner, "Введите сумму для снятия: ")
+func withdraw(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner, account *Account) {
+	if registry.IsLocked() {
+		fmt.Println("❌ Ошибка:", ErrSystemLocked)
+		return
+	}
+
+	symbol := getSymbol(scanner, "Введите символ валюты: ")
+	amount, err := getIntAmount(scanner, "Введите сумму для снятия: ")
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
 
-	err = account.Withdraw(amount)
+	err = account.Withdraw(symbol, amount)
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
 
 	store.SaveAccount(account)
-	fmt.Printf("✅ Снятие %.2f прошло успешно\n", amount)
-	fmt.Printf("   Новый баланс: %.2f\n", account.GetBalance())
+	fmt.Printf("✅ Снятие %d %s прошло успешно\n", amount, symbol)
+	fmt.Printf("   Новый баланс: %d %s\n", account.GetBalance(symbol), symbol)
 }
 
-func transfer(store *MemoryStorage, scanner *bufio.Scanner, fromAccount *Account) {
+func transfer(coordinator *TransferCoordinator, scanner *bufio.Scanner, fromAccount *Account) {
 	fmt.Print("Введите ID счета получателя: ")
 	scanner.Scan()
 	toAccountID := strings.TrimSpace(scanner.Text())
@@ -323,29 +1190,167 @@ func transfer(store *MemoryStorage, scanner *bufio.Scanner, fromAccount *Account
 		return
 	}
 
-	amount, err := getAmount(scanner, "Введите сумму для перевода: ")
+	symbol := getSymbol(scanner, "Введите символ валюты: ")
+	amount, err := getIntAmount(scanner, "Введите сумму для перевода: ")
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
 
-	toAccount, err := store.LoadAccount(toAccountID)
+	var feeTo string
+	var feeAmount int64
+	fmt.Print("Взимать комиссию? (да/нет): ")
+	scanner.Scan()
+	if strings.EqualFold(strings.TrimSpace(scanner.Text()), "да") {
+		fmt.Print("Введите ID счета получателя комиссии: ")
+		scanner.Scan()
+		feeTo = strings.TrimSpace(scanner.Text())
+		feeAmount, err = getIntAmount(scanner, "Введите сумму комиссии: ")
+		if err != nil {
+			fmt.Println("❌ Ошибка:", err)
+			return
+		}
+	}
+
+	req := TransferRequest{
+		ReferenceID: fmt.Sprintf("TRF%d", time.Now().UnixNano()),
+		From:        fromAccount.ID,
+		To:          toAccountID,
+		Symbol:      symbol,
+		Amount:      amount,
+		FeeTo:       feeTo,
+		FeeAmount:   feeAmount,
+	}
+
+	fmt.Printf("⏳ Заявка на перевод отправлена, референс: %s\n", req.ReferenceID)
+
+	state, err := coordinator.Submit(req)
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
 
-	err = fromAccount.Transfer(toAccount, amount)
+	switch state.Status {
+	case TransferSucceeded:
+		fmt.Printf("✅ Перевод на сумму %d %s выполнен успешно\n", amount, symbol)
+		fmt.Printf("   Получатель: %s\n", toAccountID)
+		fmt.Printf("   Новый баланс: %d %s\n", fromAccount.GetBalance(symbol), symbol)
+	case TransferFailed:
+		fmt.Println("❌ Перевод не выполнен:", state.Error)
+	default:
+		fmt.Printf("⌛ Перевод еще обрабатывается, статус: %s. Проверьте позже по референсу %s\n", state.Status, req.ReferenceID)
+	}
+}
+
+// scriptTransfer читает многострочный DSL-скрипт (до строки END), запрашивает
+// у пользователя ID счета для каждой переменной, объявленной в блоке vars
+// скрипта, и выполняет его через ScriptAccountService.Execute.
+func scriptTransfer(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner, account *Account) {
+	fmt.Println("\n--- ВЫПОЛНЕНИЕ СКРИПТА ПЕРЕВОДА ---")
+	fmt.Println("Введите текст скрипта, последней строкой укажите END:")
+
+	var lines []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "END" {
+			break
+		}
+		lines = append(lines, line)
+	}
+	script := strings.Join(lines, "\n")
+
+	names, err := vm.DeclaredVars(script)
+	if err != nil {
+		fmt.Println("❌ Ошибка разбора скрипта:", err)
+		return
+	}
+
+	vars := make(map[string]vm.Value, len(names))
+	for _, name := range names {
+		fmt.Printf("Введите ID счета для переменной %q: ", name)
+		scanner.Scan()
+		vars[name] = vm.Value{
+			Kind:    vm.KindAccount,
+			Account: strings.TrimSpace(scanner.Text()),
+		}
+	}
+
+	service := NewScriptAccountService(account, store, registry)
+	postings, err := service.Execute(script, vars)
+	if err != nil {
+		fmt.Println("❌ Ошибка выполнения скрипта:", err)
+		return
+	}
+
+	fmt.Println("✅ Скрипт выполнен, проводки:")
+	for _, p := range postings {
+		fmt.Printf("   %s -> %s: %d %s\n", p.Source, p.Destination, p.Amount, p.Currency)
+	}
+	fmt.Print(formatBalances(account.Balances))
+}
+
+// signedOperation авторизует снятие или перевод не через меню счета, а
+// подписью приватным ключом, вставленным пользователем: вместо того чтобы
+// доверять тому, что к терминалу сел владелец счета, Storage проверяет
+// подпись и номер последовательности операции перед тем, как ее применить.
+func signedOperation(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner, account *Account) {
+	if registry.IsLocked() {
+		fmt.Println("❌ Ошибка:", ErrSystemLocked)
+		return
+	}
+
+	fmt.Println("\n--- ОПЕРАЦИЯ ПО ПОДПИСИ ---")
+	fmt.Print("Вставьте приватный ключ счета (hex): ")
+	scanner.Scan()
+	keyHex := strings.TrimSpace(scanner.Text())
+
+	keyBytes, err := hex.DecodeString(keyHex)
+	if err != nil || len(keyBytes) != stdcrypto.PrivateKeySize {
+		fmt.Println("❌ Ошибка: некорректный приватный ключ")
+		return
+	}
+	priv := crypto.GenPrivAccountFromKey([stdcrypto.PrivateKeySize]byte(keyBytes))
+
+	fmt.Println("1. Снять средства")
+	fmt.Println("2. Перевести другому счету")
+	fmt.Print("Выберите операцию: ")
+	scanner.Scan()
+	kind := strings.TrimSpace(scanner.Text())
+
+	symbol := getSymbol(scanner, "Введите символ валюты: ")
+	amount, err := getIntAmount(scanner, "Введите сумму: ")
 	if err != nil {
 		fmt.Println("❌ Ошибка:", err)
 		return
 	}
+	sequence := account.Sequence + 1
+
+	switch kind {
+	case "1":
+		tx := &WithdrawTx{From: account.ID, Symbol: symbol, Amount: amount, Sequence: sequence}
+		tx.Signature = priv.Sign(tx.SignBytes())
+		if err := store.ApplyWithdrawTx(tx); err != nil {
+			fmt.Println("❌ Ошибка:", err)
+			return
+		}
+	case "2":
+		fmt.Print("Введите ID счета получателя: ")
+		scanner.Scan()
+		to := strings.TrimSpace(scanner.Text())
+
+		tx := &SendTx{From: account.ID, To: to, Symbol: symbol, Amount: amount, Sequence: sequence}
+		tx.Signature = priv.Sign(tx.SignBytes())
+		if err := store.ApplySendTx(tx); err != nil {
+			fmt.Println("❌ Ошибка:", err)
+			return
+		}
+	default:
+		fmt.Println("❌ Неверная опция")
+		return
+	}
 
-	store.SaveAccount(fromAccount)
-	store.SaveAccount(toAccount)
-	fmt.Printf("✅ Перевод на сумму %.2f выполнен успешно\n", amount)
-	fmt.Printf("   Получатель: %s (%s)\n", toAccount.ID, toAccount.Owner)
-	fmt.Printf("   Новый баланс: %.2f\n", fromAccount.GetBalance())
+	fmt.Printf("✅ Операция выполнена и подписана, новый номер последовательности: %d\n", sequence)
+	fmt.Print(formatBalances(account.Balances))
 }
 
 func getStatement(account *Account) {
@@ -366,17 +1371,56 @@ func listAllAccounts(store *MemoryStorage) {
 
 	fmt.Println("\n--- СПИСОК ВСЕХ СЧЕТОВ ---")
 	for i, acc := range accounts {
-		fmt.Printf("%d. %s - %s (Баланс: %.2f)\n",
-			i+1, acc.ID, acc.Owner, acc.GetBalance())
+		fmt.Printf("%d. %s - %s (%s)\n", i+1, acc.ID, acc.Owner, formatBalancesInline(acc.Balances))
+	}
+}
+
+// sortedSymbols возвращает символы валют счета в алфавитном порядке, чтобы
+// вывод балансов был детерминирован.
+func sortedSymbols(balances map[string]int64) []string {
+	symbols := make([]string, 0, len(balances))
+	for symbol := range balances {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// formatBalances форматирует балансы счета по всем валютам для отображения в
+// меню, по одной строке на валюту в алфавитном порядке символов.
+func formatBalances(balances map[string]int64) string {
+	if len(balances) == 0 {
+		return "Баланс: 0\n"
 	}
+
+	var out strings.Builder
+	for _, symbol := range sortedSymbols(balances) {
+		fmt.Fprintf(&out, "Баланс: %d %s\n", balances[symbol], symbol)
+	}
+	return out.String()
+}
+
+// formatBalancesInline - то же самое, что formatBalances, но в виде одной
+// строки через запятую, для компактного списка счетов.
+func formatBalancesInline(balances map[string]int64) string {
+	if len(balances) == 0 {
+		return "баланс: 0"
+	}
+
+	symbols := sortedSymbols(balances)
+	parts := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		parts = append(parts, fmt.Sprintf("%d %s", balances[symbol], symbol))
+	}
+	return strings.Join(parts, ", ")
 }
 
-func getAmount(scanner *bufio.Scanner, prompt string) (float64, error) {
+func getIntAmount(scanner *bufio.Scanner, prompt string) (int64, error) {
 	fmt.Print(prompt)
 	scanner.Scan()
 	amountStr := strings.TrimSpace(scanner.Text())
 
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	amount, err := strconv.ParseInt(amountStr, 10, 64)
 	if err != nil || amount <= 0 {
 		return 0, ErrInvalidAmount
 	}
@@ -384,12 +1428,191 @@ func getAmount(scanner *bufio.Scanner, prompt string) (float64, error) {
 	return amount, nil
 }
 
+func getSymbol(scanner *bufio.Scanner, prompt string) string {
+	fmt.Print(prompt)
+	scanner.Scan()
+	return strings.ToUpper(strings.TrimSpace(scanner.Text()))
+}
+
+func showAdminMenu() {
+	fmt.Println("\n═══════════════════════════════════")
+	fmt.Println("     АДМИНИСТРИРОВАНИЕ (COINBASE)")
+	fmt.Println("═══════════════════════════════════")
+	fmt.Println("1. Зарегистрировать новую валюту")
+	fmt.Println("2. Довыпустить токен")
+	fmt.Println("3. Заблокировать систему")
+	fmt.Println("4. Разблокировать систему")
+	fmt.Println("5. Вернуться в главное меню")
+	fmt.Print("Выберите опцию: ")
+}
+
+// adminMenu предоставляет доступ к операциям, которые может выполнять только
+// тот, кто докажет владение приватным ключом privileged-счета coinbase:
+// регистрация валют, довыпуск токенов и глобальная блокировка/разблокировка
+// системы. Каждый пункт запрашивает этот ключ и подписывает им свою
+// транзакцию - см. readCoinbasePriv.
+func adminMenu(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner) {
+	for {
+		showAdminMenu()
+
+		scanner.Scan()
+		input := strings.TrimSpace(scanner.Text())
+
+		switch input {
+		case "1":
+			createCurrency(store, registry, scanner)
+		case "2":
+			mintToken(store, registry, scanner)
+		case "3":
+			setLock(store, registry, scanner, true)
+		case "4":
+			setLock(store, registry, scanner, false)
+		case "5":
+			return
+		default:
+			fmt.Println("❌ Неверная опция")
+		}
+	}
+}
+
+// readCoinbasePriv запрашивает приватный ключ privileged-счета coinbase и
+// восстанавливает по нему PrivAccount, которым подписывается административная
+// транзакция - точно так же, как signedOperation подписывает
+// SendTx/WithdrawTx ключом обычного счета.
+func readCoinbasePriv(scanner *bufio.Scanner) (*crypto.PrivAccount, error) {
+	fmt.Print("Вставьте приватный ключ счета COINBASE (hex): ")
+	scanner.Scan()
+	keyHex := strings.TrimSpace(scanner.Text())
+
+	keyBytes, err := hex.DecodeString(keyHex)
+	if err != nil || len(keyBytes) != stdcrypto.PrivateKeySize {
+		return nil, errors.New("некорректный приватный ключ")
+	}
+	return crypto.GenPrivAccountFromKey([stdcrypto.PrivateKeySize]byte(keyBytes)), nil
+}
+
+func createCurrency(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner) {
+	fmt.Print("Введите название валюты: ")
+	scanner.Scan()
+	name := strings.TrimSpace(scanner.Text())
+
+	symbol := getSymbol(scanner, "Введите символ валюты: ")
+
+	fmt.Print("Введите начальную эмиссию: ")
+	scanner.Scan()
+	initialSupply, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 64)
+	if err != nil || initialSupply < 0 {
+		fmt.Println("❌ Ошибка:", ErrInvalidAmount)
+		return
+	}
+
+	priv, err := readCoinbasePriv(scanner)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+	coinbase, err := store.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	tx := &InitCurrencyTx{Name: name, Symbol: symbol, InitialSupply: initialSupply, Sequence: coinbase.Sequence + 1}
+	tx.Signature = priv.Sign(tx.SignBytes())
+
+	currency, err := registry.InitCurrency(store, tx)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	fmt.Printf("✅ Валюта %s (%s) зарегистрирована, начальная эмиссия %d зачислена на счет %s\n",
+		currency.Symbol, currency.Name, currency.InitialSupply, CoinbaseAccountID)
+}
+
+func mintToken(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner) {
+	symbol := getSymbol(scanner, "Введите символ валюты: ")
+	amount, err := getIntAmount(scanner, "Введите сумму довыпуска: ")
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	priv, err := readCoinbasePriv(scanner)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+	coinbase, err := store.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	tx := &MintTx{Symbol: symbol, Amount: amount, Sequence: coinbase.Sequence + 1}
+	tx.Signature = priv.Sign(tx.SignBytes())
+
+	if err := registry.MintToken(store, tx); err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	fmt.Printf("✅ Довыпущено %d %s на счет %s\n", amount, symbol, CoinbaseAccountID)
+}
+
+// setLock запрашивает приватный ключ coinbase и, подписав им SetLockTx,
+// включает или выключает глобальную блокировку системы.
+func setLock(store *MemoryStorage, registry *CurrencyRegistry, scanner *bufio.Scanner, locked bool) {
+	priv, err := readCoinbasePriv(scanner)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+	coinbase, err := store.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	tx := &SetLockTx{Locked: locked, Sequence: coinbase.Sequence + 1}
+	tx.Signature = priv.Sign(tx.SignBytes())
+
+	if err := registry.SetLock(store, tx); err != nil {
+		fmt.Println("❌ Ошибка:", err)
+		return
+	}
+
+	if locked {
+		fmt.Println("🔒 Система заблокирована")
+	} else {
+		fmt.Println("🔓 Система разблокирована")
+	}
+}
+
 func main() {
 	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+	coordinator := NewTransferCoordinator(store, NewMemoryPersistence(), registry)
 	scanner := bufio.NewScanner(os.Stdin)
 
+	coinbasePriv, err := crypto.GenPrivAccount()
+	if err != nil {
+		fmt.Println("Не удалось сгенерировать ключ счета COINBASE:", err)
+		return
+	}
+	store.SaveAccount(&Account{
+		ID:       CoinbaseAccountID,
+		Owner:    "Coinbase",
+		PubKey:   coinbasePriv.PubKey,
+		Balances: make(map[string]int64),
+	})
+
 	fmt.Println("=== БАНКОВСКОЕ ПРИЛОЖЕНИЕ ===")
 	fmt.Println("Добро пожаловать в банковскую систему!")
+	fmt.Println("⚠️  Приватный ключ привилегированного счета COINBASE (нужен в меню")
+	fmt.Println("   администрирования для регистрации валют, довыпуска токенов и")
+	fmt.Println("   блокировки системы):")
+	fmt.Println("  ", hex.EncodeToString(coinbasePriv.Key[:]))
 
 	for {
 		showMainMenu()
@@ -401,14 +1624,16 @@ func main() {
 		case "1":
 			createAccount(store, scanner)
 		case "2":
-			selectAccountMenu(store, scanner)
+			selectAccountMenu(store, coordinator, registry, scanner)
 		case "3":
 			listAllAccounts(store)
 		case "4":
+			adminMenu(store, registry, scanner)
+		case "5":
 			fmt.Println("Выход из приложения. До свидания!")
 			return
 		default:
-			fmt.Println("❌ Неверная опция. Пожалуйста, выберите от 1 до 4")
+			fmt.Println("❌ Неверная опция. Пожалуйста, выберите от 1 до 5")
 		}
 	}
 }
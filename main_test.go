@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/weeckee/function-7-8/crypto"
+	"github.com/weeckee/function-7-8/machine/vm"
+)
+
+func newTestRegistryWithCoinbase(t *testing.T) (*MemoryStorage, *CurrencyRegistry, *crypto.PrivAccount) {
+	t.Helper()
+
+	priv, err := crypto.GenPrivAccount()
+	if err != nil {
+		t.Fatalf("GenPrivAccount() вернул ошибку: %v", err)
+	}
+
+	store := NewMemoryStorage()
+	store.SaveAccount(&Account{ID: CoinbaseAccountID, Owner: "Coinbase", PubKey: priv.PubKey, Balances: map[string]int64{}})
+
+	return store, NewCurrencyRegistry(), priv
+}
+
+func newTestCoordinator() (*TransferCoordinator, *MemoryStorage) {
+	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+	coordinator := NewTransferCoordinator(store, NewMemoryPersistence(), registry)
+
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", Balances: map[string]int64{"USD": 1000}})
+	store.SaveAccount(&Account{ID: "ACC0002", Owner: "Bob", Balances: map[string]int64{}})
+
+	return coordinator, store
+}
+
+// Повторная отправка заявки с уже обработанным ReferenceID не должна снимать
+// средства дважды: Submit обязан вернуть результат, к которому сошлась сага
+// в первый раз, не повторяя списание.
+func TestTransferCoordinatorSubmitIsIdempotent(t *testing.T) {
+	coordinator, store := newTestCoordinator()
+
+	req := TransferRequest{
+		ReferenceID: "TRF-TEST-1",
+		From:        "ACC0001",
+		To:          "ACC0002",
+		Symbol:      "USD",
+		Amount:      100,
+	}
+
+	first, err := coordinator.Submit(req)
+	if err != nil {
+		t.Fatalf("первая отправка вернула ошибку: %v", err)
+	}
+	if first.Status != TransferSucceeded {
+		t.Fatalf("первая отправка завершилась статусом %s, ожидался %s", first.Status, TransferSucceeded)
+	}
+
+	second, err := coordinator.Submit(req)
+	if err != nil {
+		t.Fatalf("повторная отправка вернула ошибку: %v", err)
+	}
+	if second.Status != TransferSucceeded {
+		t.Fatalf("повторная отправка завершилась статусом %s, ожидался %s", second.Status, TransferSucceeded)
+	}
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	to, err := store.LoadAccount("ACC0002")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0002) вернул ошибку: %v", err)
+	}
+
+	if got := from.GetBalance("USD"); got != 900 {
+		t.Errorf("баланс отправителя = %d, ожидалось 900 (списание не должно повториться)", got)
+	}
+	if got := to.GetBalance("USD"); got != 100 {
+		t.Errorf("баланс получателя = %d, ожидалось 100 (зачисление не должно повториться)", got)
+	}
+}
+
+// Включенная глобальная блокировка должна останавливать перевод до того, как
+// он спишет хоть что-то со счета отправителя.
+func TestTransferCoordinatorSubmitRejectsWhenLocked(t *testing.T) {
+	store, registry, priv := newTestRegistryWithCoinbase(t)
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", Balances: map[string]int64{"USD": 1000}})
+	store.SaveAccount(&Account{ID: "ACC0002", Owner: "Bob", Balances: map[string]int64{}})
+
+	lockTx := &SetLockTx{Locked: true, Sequence: 1}
+	lockTx.Signature = priv.Sign(lockTx.SignBytes())
+	if err := registry.SetLock(store, lockTx); err != nil {
+		t.Fatalf("SetLock() вернул ошибку: %v", err)
+	}
+
+	coordinator := NewTransferCoordinator(store, NewMemoryPersistence(), registry)
+	_, err := coordinator.Submit(TransferRequest{
+		ReferenceID: "TRF-TEST-LOCKED",
+		From:        "ACC0001",
+		To:          "ACC0002",
+		Symbol:      "USD",
+		Amount:      100,
+	})
+	if err != ErrSystemLocked {
+		t.Fatalf("Submit() при заблокированной системе вернул %v, ожидалась ErrSystemLocked", err)
+	}
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	if got := from.GetBalance("USD"); got != 1000 {
+		t.Errorf("баланс отправителя = %d, ожидалось 1000 (перевод не должен был пройти)", got)
+	}
+}
+
+// Минтинг токена без подписи приватным ключом coinbase (либо с подписью,
+// выполненной посторонним ключом) должен отклоняться - ownerID-строка сама
+// по себе больше не является доказательством полномочий.
+func TestCurrencyRegistryMintTokenRejectsWrongSigner(t *testing.T) {
+	store, registry, priv := newTestRegistryWithCoinbase(t)
+
+	initTx := &InitCurrencyTx{Name: "Test Coin", Symbol: "TST", InitialSupply: 0, Sequence: 1}
+	initTx.Signature = priv.Sign(initTx.SignBytes())
+	if _, err := registry.InitCurrency(store, initTx); err != nil {
+		t.Fatalf("InitCurrency() вернул ошибку: %v", err)
+	}
+
+	impostor, err := crypto.GenPrivAccount()
+	if err != nil {
+		t.Fatalf("GenPrivAccount() вернул ошибку: %v", err)
+	}
+
+	mintTx := &MintTx{Symbol: "TST", Amount: 1000, Sequence: 2}
+	mintTx.Signature = impostor.Sign(mintTx.SignBytes())
+
+	if err := registry.MintToken(store, mintTx); err != ErrInvalidSignature {
+		t.Fatalf("MintToken() с чужой подписью вернул %v, ожидалась ErrInvalidSignature", err)
+	}
+
+	coinbase, err := store.LoadAccount(CoinbaseAccountID)
+	if err != nil {
+		t.Fatalf("LoadAccount(COINBASE) вернул ошибку: %v", err)
+	}
+	if got := coinbase.GetBalance("TST"); got != 0 {
+		t.Errorf("баланс coinbase = %d, ожидалось 0 (довыпуск с чужой подписью не должен был пройти)", got)
+	}
+}
+
+// flakyCommitPersistence оборачивает MemoryPersistence и возвращает транзиентную
+// ошибку (не ErrStorageConflict) на первые failures вызовов CompareAndSwap,
+// у которых expected.Claimed == true, т.е. на коммит, снимающий claim.
+type flakyCommitPersistence struct {
+	*MemoryPersistence
+	failuresLeft int
+}
+
+var errTransientPersistence = errors.New("временная ошибка хранилища состояния")
+
+func (fp *flakyCommitPersistence) CompareAndSwap(key string, new *TransferState, expected *TransferState) error {
+	if expected != nil && expected.Claimed && fp.failuresLeft > 0 {
+		fp.failuresLeft--
+		return errTransientPersistence
+	}
+	return fp.MemoryPersistence.CompareAndSwap(key, new, expected)
+}
+
+// Транзиентная (не конфликтная) ошибка при снятии claim не должна приводить
+// к тому, что сага застревает в Claimed=true навсегда - run() обязан
+// повторить именно коммит уже вычисленного next, а не сдаваться после
+// первой неудачи.
+func TestTransferCoordinatorRunRetriesTransientCommitFailure(t *testing.T) {
+	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+	persistence := &flakyCommitPersistence{MemoryPersistence: NewMemoryPersistence(), failuresLeft: 2}
+	coordinator := NewTransferCoordinator(store, persistence, registry)
+
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", Balances: map[string]int64{"USD": 1000}})
+	store.SaveAccount(&Account{ID: "ACC0002", Owner: "Bob", Balances: map[string]int64{}})
+
+	state, err := coordinator.Submit(TransferRequest{
+		ReferenceID: "TRF-TEST-FLAKY",
+		From:        "ACC0001",
+		To:          "ACC0002",
+		Symbol:      "USD",
+		Amount:      100,
+	})
+	if err != nil {
+		t.Fatalf("Submit() вернул ошибку: %v", err)
+	}
+	if state.Status != TransferSucceeded {
+		t.Fatalf("Submit() завершился статусом %s, ожидался %s", state.Status, TransferSucceeded)
+	}
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	to, err := store.LoadAccount("ACC0002")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0002) вернул ошибку: %v", err)
+	}
+	if got := from.GetBalance("USD"); got != 900 {
+		t.Errorf("баланс отправителя = %d, ожидалось 900 (повтор коммита не должен был повторить списание)", got)
+	}
+	if got := to.GetBalance("USD"); got != 100 {
+		t.Errorf("баланс получателя = %d, ожидалось 100", got)
+	}
+}
+
+// applyPostings должна отклонять проводку, списывающую больше, чем реально
+// есть на счете источника, вместо того чтобы молча увести баланс в минус -
+// в отличие от прежней реализации, списывавшей сумму напрямую в обход
+// проверки достаточности средств, которую делает debit.
+func TestScriptAccountServiceApplyPostingsRejectsOverdraft(t *testing.T) {
+	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", Balances: map[string]int64{"USD": 11}})
+	store.SaveAccount(&Account{ID: "ACC0002", Owner: "Bob", Balances: map[string]int64{}})
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	service := NewScriptAccountService(from, store, registry)
+
+	err = service.applyPostings([]vm.Posting{
+		{Source: "ACC0001", Destination: "ACC0002", Currency: "USD", Amount: 10000},
+	})
+	if err != ErrInsufficientFunds {
+		t.Fatalf("applyPostings() с проводкой сверх баланса вернул %v, ожидалась ErrInsufficientFunds", err)
+	}
+
+	reloaded, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	if got := reloaded.GetBalance("USD"); got != 11 {
+		t.Errorf("баланс отправителя = %d, ожидалось 11 (отклоненная проводка не должна была списаться)", got)
+	}
+}
+
+// Если одна из проводок скрипта падает по нехватке средств, ранее успешно
+// обработанные проводки того же вызова applyPostings не должны становиться
+// видимыми - иначе "неудавшийся" скрипт частично бы исполнился.
+func TestScriptAccountServiceApplyPostingsIsAtomic(t *testing.T) {
+	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", Balances: map[string]int64{"USD": 10}})
+	store.SaveAccount(&Account{ID: "ACC0002", Owner: "Bob", Balances: map[string]int64{}})
+	store.SaveAccount(&Account{ID: "ACC0003", Owner: "Carol", Balances: map[string]int64{}})
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	service := NewScriptAccountService(from, store, registry)
+
+	err = service.applyPostings([]vm.Posting{
+		{Source: "ACC0001", Destination: "ACC0002", Currency: "USD", Amount: 5},
+		{Source: "ACC0001", Destination: "ACC0003", Currency: "USD", Amount: 10000},
+	})
+	if err != ErrInsufficientFunds {
+		t.Fatalf("applyPostings() вернул %v, ожидалась ErrInsufficientFunds", err)
+	}
+
+	accFrom, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	accTo, err := store.LoadAccount("ACC0002")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0002) вернул ошибку: %v", err)
+	}
+	if got := accFrom.GetBalance("USD"); got != 10 {
+		t.Errorf("баланс ACC0001 = %d, ожидалось 10 (ни одна проводка упавшего скрипта не должна была примениться)", got)
+	}
+	if got := accTo.GetBalance("USD"); got != 0 {
+		t.Errorf("баланс ACC0002 = %d, ожидалось 0 (ни одна проводка упавшего скрипта не должна была примениться)", got)
+	}
+}
+
+// staleClaimPersistence форсирует Claimed=true на первые claimedLoadsLeft
+// вызовов Load, имитируя владельца claim, которому нужно больше итераций
+// ожидания, чем maxTransferAttempts, чтобы закоммитить свой шаг.
+type staleClaimPersistence struct {
+	*MemoryPersistence
+	claimedLoadsLeft int
+}
+
+func (sp *staleClaimPersistence) Load(key string) (*TransferState, error) {
+	state, err := sp.MemoryPersistence.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	if sp.claimedLoadsLeft > 0 {
+		sp.claimedLoadsLeft--
+		stillClaimed := *state
+		stillClaimed.Claimed = true
+		return &stillClaimed, nil
+	}
+	return state, nil
+}
+
+// Ожидание чужого claim не должно делить один бюджет попыток с коммитом
+// владельца: если снятие claim требует больше итераций ожидания, чем
+// maxTransferAttempts, run() обязан продолжать ждать (в пределах
+// maxClaimWaitAttempts), а не возвращать ErrTransferTimedOut раньше времени
+// для перевода, который на самом деле благополучно завершился.
+func TestTransferCoordinatorRunWaitsLongerThanStepAttemptsForClaimRelease(t *testing.T) {
+	persistence := &staleClaimPersistence{MemoryPersistence: NewMemoryPersistence(), claimedLoadsLeft: 10}
+	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+	coordinator := NewTransferCoordinator(store, persistence, registry)
+
+	seed := &TransferState{
+		ReferenceID: "TRF-TEST-STALE-CLAIM",
+		From:        "ACC0001",
+		To:          "ACC0002",
+		Symbol:      "USD",
+		Amount:      100,
+		Status:      TransferSucceeded,
+	}
+	if err := persistence.CompareAndSwap(seed.ReferenceID, seed, nil); err != nil {
+		t.Fatalf("не удалось засеять состояние: %v", err)
+	}
+
+	claimedState := *seed
+	claimedState.Status = TransferWithdrawing
+	claimedState.Claimed = true
+
+	state, err := coordinator.run(&claimedState)
+	if err != nil {
+		t.Fatalf("run() вернул ошибку %v, хотя claim владельца в итоге снимается", err)
+	}
+	if state.Status != TransferSucceeded {
+		t.Fatalf("run() вернул статус %s, ожидался %s", state.Status, TransferSucceeded)
+	}
+}
+
+// Два конкурентных вызова run() над одной и той же перечитанной сагой (гонка
+// CAS-проигравшего либо дублирующийся Submit не завершившейся заявки) не
+// должны независимо повторять списание: ровно один из них обязан выполнить
+// мутацию Storage для статуса Withdrawing, второй - дождаться ее результата.
+func TestTransferCoordinatorRunIsSafeUnderConcurrentCallers(t *testing.T) {
+	store := NewMemoryStorage()
+	registry := NewCurrencyRegistry()
+	persistence := NewMemoryPersistence()
+	coordinator := NewTransferCoordinator(store, persistence, registry)
+
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", Balances: map[string]int64{"USD": 1000}})
+	store.SaveAccount(&Account{ID: "ACC0002", Owner: "Bob", Balances: map[string]int64{}})
+
+	seed := &TransferState{
+		ReferenceID: "TRF-TEST-RACE",
+		From:        "ACC0001",
+		To:          "ACC0002",
+		Symbol:      "USD",
+		Amount:      100,
+		Status:      TransferWithdrawing,
+	}
+	if err := persistence.CompareAndSwap(seed.ReferenceID, seed, nil); err != nil {
+		t.Fatalf("не удалось засеять состояние: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, err := persistence.Load(seed.ReferenceID)
+			if err != nil {
+				t.Errorf("Load() вернул ошибку: %v", err)
+				return
+			}
+			if _, err := coordinator.run(state); err != nil {
+				t.Errorf("run() вернул ошибку: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	to, err := store.LoadAccount("ACC0002")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0002) вернул ошибку: %v", err)
+	}
+
+	if got := from.GetBalance("USD"); got != 900 {
+		t.Errorf("баланс отправителя = %d, ожидалось 900 (списание не должно было повториться)", got)
+	}
+	if got := to.GetBalance("USD"); got != 100 {
+		t.Errorf("баланс получателя = %d, ожидалось 100 (зачисление должно было произойти один раз)", got)
+	}
+}
+
+// Повторное применение уже принятой WithdrawTx (replay той же подписи и
+// того же Sequence) должно отклоняться: Sequence счета уже продвинулся
+// вперед, поэтому sequence транзакции больше не равен account.Sequence+1.
+func TestMemoryStorageApplyWithdrawTxRejectsReplay(t *testing.T) {
+	priv, err := crypto.GenPrivAccount()
+	if err != nil {
+		t.Fatalf("GenPrivAccount() вернул ошибку: %v", err)
+	}
+
+	store := NewMemoryStorage()
+	store.SaveAccount(&Account{ID: "ACC0001", Owner: "Alice", PubKey: priv.PubKey, Balances: map[string]int64{"USD": 1000}})
+
+	tx := &WithdrawTx{From: "ACC0001", Symbol: "USD", Amount: 100, Sequence: 1}
+	tx.Signature = priv.Sign(tx.SignBytes())
+
+	if err := store.ApplyWithdrawTx(tx); err != nil {
+		t.Fatalf("первое применение WithdrawTx вернуло ошибку: %v", err)
+	}
+
+	if err := store.ApplyWithdrawTx(tx); err != ErrInvalidSequence {
+		t.Fatalf("повторное применение той же WithdrawTx вернуло %v, ожидалась ErrInvalidSequence", err)
+	}
+
+	from, err := store.LoadAccount("ACC0001")
+	if err != nil {
+		t.Fatalf("LoadAccount(ACC0001) вернул ошибку: %v", err)
+	}
+	if got := from.GetBalance("USD"); got != 900 {
+		t.Errorf("баланс = %d, ожидалось 900 (повторное снятие не должно было пройти)", got)
+	}
+}